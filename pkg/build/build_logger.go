@@ -1,6 +1,7 @@
 package build
 
 import (
+	"compress/gzip"
 	"fmt"
 	"github.com/moby/buildkit/client"
 	"github.com/pkg/errors"
@@ -22,19 +23,62 @@ type Logger interface {
 	AddLogLineListener(func(service, logLine string))
 }
 
+//LogReader is implemented by Loggers that can replay past log output, so
+//e.g. the `sanic logs` command can surface history across builds
+type LogReader interface {
+	ReadLogs(service string, since time.Time) (io.ReadCloser, error)
+}
+
+//FlatfileLoggerOptions configures rotation, retention, and compression for
+//NewFlatfileLoggerWithOptions
+type FlatfileLoggerOptions struct {
+	//MaxArchives caps how many rotated archives are kept per service; the
+	//oldest are deleted first once the cap is exceeded. Zero means unlimited.
+	MaxArchives int
+	//MaxTotalBytes caps the combined size of a service's archives; the
+	//oldest are deleted first once the cap is exceeded. Zero means unlimited.
+	MaxTotalBytes int64
+	//Compress gzips rotated archives once they're older than RetainFor
+	Compress bool
+	//RetainFor is how long a rotated archive is kept uncompressed before
+	//Compress (if set) gzips it
+	RetainFor time.Duration
+}
+
+//defaultFlatfileLoggerOptions is used by NewFlatfileLogger
+var defaultFlatfileLoggerOptions = FlatfileLoggerOptions{
+	MaxArchives:   10,
+	MaxTotalBytes: 100 * 1024 * 1024,
+	Compress:      true,
+	RetainFor:     24 * time.Hour,
+}
+
 type flatfileLogger struct {
 	mutex              sync.Mutex
 	LogDirectory       string
+	options            FlatfileLoggerOptions
 	currVertexStatuses map[string]string
 	openFiles          map[string]*os.File
 	logLineListeners   []func(service, logLine string)
 	verbose            bool
 }
 
-//NewFlatfileLogger builds a new Logger which writes text logs to (repository root)/logs/(service name).log
+//NewFlatfileLogger builds a new Logger which writes text logs to (repository
+//root)/logs/(service name).log, with sensible rotation/retention defaults.
+//See NewFlatfileLoggerWithOptions to customize them.
 func NewFlatfileLogger(logDirectory string, verbose bool) Logger {
+	return NewFlatfileLoggerWithOptions(logDirectory, verbose, defaultFlatfileLoggerOptions)
+}
+
+//NewFlatfileLoggerWithOptions builds a new Logger which writes text logs to
+//(repository root)/logs/(service name).log. Every time a service's log is
+//opened, any log left over from the previous build is rotated out to
+//(service name).log.<timestamp> rather than truncated, so build history
+//isn't lost, and options bounds how many of those archives are kept.
+func NewFlatfileLoggerWithOptions(logDirectory string, verbose bool, options FlatfileLoggerOptions) Logger {
 	return &flatfileLogger{
 		LogDirectory:       logDirectory,
+		options:            options,
 		openFiles:          make(map[string]*os.File),
 		currVertexStatuses: make(map[string]string),
 		logLineListeners:   []func(service, logLine string){},
@@ -46,28 +90,217 @@ func (logger *flatfileLogger) logFile(service string) (*os.File, error) {
 	logger.mutex.Lock()
 	defer logger.mutex.Unlock()
 
-	var logFile *os.File
-
 	if existingFile, ok := logger.openFiles[service]; ok {
-		logFile = existingFile
-	} else {
-		err := os.MkdirAll(logger.LogDirectory, 0700)
+		return existingFile, nil
+	}
+
+	if err := os.MkdirAll(logger.LogDirectory, 0700); err != nil {
+		return nil, errors.Errorf(
+			"Could not make the logs output directory at %s: %s",
+			logger.LogDirectory,
+			err.Error())
+	}
+
+	currentPath := filepath.Join(logger.LogDirectory, service+".log")
+	if info, err := os.Stat(currentPath); err == nil && info.Size() > 0 {
+		archivePath := filepath.Join(logger.LogDirectory, fmt.Sprintf("%s.log.%d", service, time.Now().Unix()))
+		if err := os.Rename(currentPath, archivePath); err != nil {
+			return nil, errors.Errorf("Could not rotate %s's previous log: %s", service, err.Error())
+		}
+	}
+
+	//note: no O_APPEND - logStatus seeks backward to overwrite in-progress
+	//vertex statuses in place, which O_APPEND would silently defeat
+	logFile, err := os.OpenFile(currentPath, os.O_WRONLY|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	logger.openFiles[service] = logFile
+
+	if err := logger.enforceRetention(service); err != nil {
+		return nil, err
+	}
+
+	return logFile, nil
+}
+
+//logArchive is a single rotated log file belonging to a service, compressed
+//or not
+type logArchive struct {
+	path    string
+	modTime time.Time
+	size    int64
+}
+
+func (logger *flatfileLogger) listArchives(service string) ([]logArchive, error) {
+	matches, err := filepath.Glob(filepath.Join(logger.LogDirectory, service+".log.*"))
+	if err != nil {
+		return nil, err
+	}
+	archives := make([]logArchive, 0, len(matches))
+	for _, path := range matches {
+		info, err := os.Stat(path)
 		if err != nil {
-			return nil, errors.Errorf(
-				"Could not make the logs output directory at %s: %s",
-				logger.LogDirectory,
-				err.Error())
-		}
-		logFile, err = os.OpenFile(
-			filepath.Join(logger.LogDirectory, service+".log"),
-			os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+			continue
+		}
+		archives = append(archives, logArchive{path: path, modTime: info.ModTime(), size: info.Size()})
+	}
+	sort.Slice(archives, func(i, j int) bool {
+		return archives[i].modTime.Before(archives[j].modTime)
+	})
+	return archives, nil
+}
+
+//enforceRetention compresses archives older than options.RetainFor, then
+//deletes the oldest archives until neither MaxArchives nor MaxTotalBytes is
+//exceeded
+func (logger *flatfileLogger) enforceRetention(service string) error {
+	archives, err := logger.listArchives(service)
+	if err != nil {
+		return err
+	}
+
+	if logger.options.Compress {
+		cutoff := time.Now().Add(-logger.options.RetainFor)
+		for i, archive := range archives {
+			if strings.HasSuffix(archive.path, ".gz") || archive.modTime.After(cutoff) {
+				continue
+			}
+			compressedPath, err := gzipFile(archive.path)
+			if err != nil {
+				return errors.Errorf("Could not compress archived log %s: %s", archive.path, err.Error())
+			}
+			//keep the original mtime so the archive still sorts as the oldest
+			if err := os.Chtimes(compressedPath, archive.modTime, archive.modTime); err != nil {
+				return err
+			}
+			info, err := os.Stat(compressedPath)
+			if err != nil {
+				return err
+			}
+			archives[i] = logArchive{path: compressedPath, modTime: archive.modTime, size: info.Size()}
+		}
+	}
+
+	var totalBytes int64
+	for _, archive := range archives {
+		totalBytes += archive.size
+	}
+
+	for len(archives) > 0 && logger.exceedsRetention(len(archives), totalBytes) {
+		oldest := archives[0]
+		if err := os.Remove(oldest.path); err != nil {
+			return errors.Errorf("Could not delete old log archive %s: %s", oldest.path, err.Error())
+		}
+		totalBytes -= oldest.size
+		archives = archives[1:]
+	}
+	return nil
+}
+
+func (logger *flatfileLogger) exceedsRetention(archiveCount int, totalBytes int64) bool {
+	if logger.options.MaxArchives > 0 && archiveCount > logger.options.MaxArchives {
+		return true
+	}
+	if logger.options.MaxTotalBytes > 0 && totalBytes > logger.options.MaxTotalBytes {
+		return true
+	}
+	return false
+}
+
+func gzipFile(path string) (string, error) {
+	in, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer in.Close()
+
+	compressedPath := path + ".gz"
+	out, err := os.OpenFile(compressedPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return "", err
+	}
+	defer out.Close()
+
+	gzWriter := gzip.NewWriter(out)
+	if _, err := io.Copy(gzWriter, in); err != nil {
+		gzWriter.Close()
+		return "", err
+	}
+	if err := gzWriter.Close(); err != nil {
+		return "", err
+	}
+
+	return compressedPath, os.Remove(path)
+}
+
+//ReadLogs returns a ReadCloser that stitches together a service's archives
+//(compressed or not) modified at or after since, followed by its current
+//log, in chronological order - so `sanic logs` can surface cross-build
+//history transparently
+func (logger *flatfileLogger) ReadLogs(service string, since time.Time) (io.ReadCloser, error) {
+	archives, err := logger.listArchives(service)
+	if err != nil {
+		return nil, err
+	}
+
+	var readers []io.Reader
+	var closers []io.Closer
+	closeAll := func() {
+		for _, c := range closers {
+			c.Close()
+		}
+	}
+
+	for _, archive := range archives {
+		if archive.modTime.Before(since) {
+			continue
+		}
+		f, err := os.Open(archive.path)
 		if err != nil {
+			closeAll()
 			return nil, err
 		}
-		logFile.WriteString("") //wipe old logs
-		logger.openFiles[service] = logFile
+		closers = append(closers, f)
+
+		if !strings.HasSuffix(archive.path, ".gz") {
+			readers = append(readers, f)
+			continue
+		}
+		gzReader, err := gzip.NewReader(f)
+		if err != nil {
+			closeAll()
+			return nil, errors.Errorf("Could not read compressed log archive %s: %s", archive.path, err.Error())
+		}
+		closers = append(closers, gzReader)
+		readers = append(readers, gzReader)
 	}
-	return logFile, nil
+
+	currentPath := filepath.Join(logger.LogDirectory, service+".log")
+	if f, err := os.Open(currentPath); err == nil {
+		closers = append(closers, f)
+		readers = append(readers, f)
+	} else if !os.IsNotExist(err) {
+		closeAll()
+		return nil, err
+	}
+
+	return &multiReadCloser{Reader: io.MultiReader(readers...), closers: closers}, nil
+}
+
+type multiReadCloser struct {
+	io.Reader
+	closers []io.Closer
+}
+
+func (m *multiReadCloser) Close() error {
+	var firstErr error
+	for _, c := range m.closers {
+		if err := c.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
 }
 
 func (logger *flatfileLogger) Log(service string, when time.Time, message ...interface{}) error {
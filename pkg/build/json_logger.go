@@ -0,0 +1,170 @@
+package build
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/moby/buildkit/client"
+	"github.com/pkg/errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+//jsonLogRecord is one line of output from a jsonLinesLogger. Fields that
+//don't apply to a given event_type are left zero and omitted.
+type jsonLogRecord struct {
+	Service      string    `json:"service"`
+	Timestamp    time.Time `json:"timestamp"`
+	EventType    string    `json:"event_type"` //"log", "vertex", or "status"
+	VertexName   string    `json:"vertex_name,omitempty"`
+	VertexDigest string    `json:"vertex_digest,omitempty"`
+	Cached       bool      `json:"cached,omitempty"`
+	Error        string    `json:"error,omitempty"`
+	StatusID     string    `json:"status_id,omitempty"`
+	Current      int64     `json:"current,omitempty"`
+	Total        int64     `json:"total,omitempty"`
+	Message      string    `json:"message,omitempty"`
+}
+
+type jsonLinesLogger struct {
+	mutex            sync.Mutex
+	perService       bool
+	logDirectory     string
+	openFiles        map[string]*os.File
+	combined         io.Writer
+	logLineListeners []func(service, logLine string)
+}
+
+//NewJSONLinesLogger builds a Logger which writes one JSON object per line
+//describing buildkit activity, so downstream tools (Elasticsearch, Loki,
+//Fluentd) can ingest it without parsing flatfileLogger's ad-hoc text format.
+//If logDirectory is non-empty, one file (service name).jsonl is written per
+//service; otherwise every record is written to the combined stream.
+func NewJSONLinesLogger(logDirectory string, combined io.Writer) Logger {
+	return &jsonLinesLogger{
+		perService:       logDirectory != "",
+		logDirectory:     logDirectory,
+		openFiles:        make(map[string]*os.File),
+		combined:         combined,
+		logLineListeners: []func(service, logLine string){},
+	}
+}
+
+func (logger *jsonLinesLogger) writer(service string) (io.Writer, error) {
+	logger.mutex.Lock()
+	defer logger.mutex.Unlock()
+
+	if !logger.perService {
+		return logger.combined, nil
+	}
+
+	if existingFile, ok := logger.openFiles[service]; ok {
+		return existingFile, nil
+	}
+
+	if err := os.MkdirAll(logger.logDirectory, 0700); err != nil {
+		return nil, errors.Errorf(
+			"Could not make the logs output directory at %s: %s",
+			logger.logDirectory,
+			err.Error())
+	}
+	logFile, err := os.OpenFile(
+		filepath.Join(logger.logDirectory, service+".jsonl"),
+		os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, err
+	}
+	logger.openFiles[service] = logFile
+	return logFile, nil
+}
+
+func (logger *jsonLinesLogger) write(service string, record jsonLogRecord) error {
+	w, err := logger.writer(service)
+	if err != nil {
+		return err
+	}
+	encoded, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	logger.mutex.Lock()
+	_, err = w.Write(append(encoded, '\n'))
+	logger.mutex.Unlock()
+	if err != nil {
+		return err
+	}
+
+	for _, listener := range logger.logLineListeners {
+		listener(service, string(encoded)+"\n")
+	}
+	return nil
+}
+
+func (logger *jsonLinesLogger) Log(service string, when time.Time, message ...interface{}) error {
+	return logger.write(service, jsonLogRecord{
+		Service:   service,
+		Timestamp: when,
+		EventType: "log",
+		Message:   strings.Trim(fmt.Sprint(message...), "\r\n"),
+	})
+}
+
+func (logger *jsonLinesLogger) ProcessStatus(service string, status *client.SolveStatus) error {
+	for _, v := range status.Vertexes {
+		if err := logger.write(service, jsonLogRecord{
+			Service:      service,
+			Timestamp:    time.Now(),
+			EventType:    "vertex",
+			VertexName:   v.Name,
+			VertexDigest: v.Digest.String(),
+			Cached:       v.Cached,
+			Error:        v.Error,
+		}); err != nil {
+			return errors.Errorf("Could not write vertex record for %s: %s", service, err.Error())
+		}
+	}
+
+	for _, vs := range status.Statuses {
+		if err := logger.write(service, jsonLogRecord{
+			Service:      service,
+			Timestamp:    vs.Timestamp,
+			EventType:    "status",
+			VertexDigest: vs.Vertex.String(),
+			StatusID:     vs.ID,
+			Current:      vs.Current,
+			Total:        vs.Total,
+		}); err != nil {
+			return errors.Errorf("Could not write status record for %s: %s", service, err.Error())
+		}
+	}
+
+	for _, log := range status.Logs {
+		if err := logger.write(service, jsonLogRecord{
+			Service:      service,
+			Timestamp:    log.Timestamp,
+			EventType:    "log",
+			VertexDigest: log.Vertex.String(),
+			Message:      strings.Trim(string(log.Data), "\r\n"),
+		}); err != nil {
+			return errors.Errorf("Could not write log record for %s: %s", service, err.Error())
+		}
+	}
+	return nil
+}
+
+func (logger *jsonLinesLogger) Close() {
+	logger.mutex.Lock()
+	defer logger.mutex.Unlock()
+
+	for _, f := range logger.openFiles {
+		f.Close()
+	}
+}
+
+func (logger *jsonLinesLogger) AddLogLineListener(processLog func(service, logLine string)) {
+	logger.logLineListeners = append(logger.logLineListeners, processLog)
+}
@@ -0,0 +1,51 @@
+package build
+
+import (
+	"github.com/moby/buildkit/client"
+	"time"
+)
+
+//teeLogger fans a single stream of build activity out to multiple Loggers,
+//so e.g. a flatfileLogger and a terminal/JSON logger can be driven together
+type teeLogger struct {
+	loggers []Logger
+}
+
+//Tee combines multiple Loggers into a single Logger, forwarding every call to
+//each of them in order. Log and ProcessStatus stop at, and return, the first
+//error, so the loggers after a failing one don't receive that call. This
+//centralizes the fan-out so callers of ProcessStatus don't have to pick a
+//single logger themselves.
+func Tee(loggers ...Logger) Logger {
+	return &teeLogger{loggers: loggers}
+}
+
+func (tee *teeLogger) Log(service string, when time.Time, message ...interface{}) error {
+	for _, logger := range tee.loggers {
+		if err := logger.Log(service, when, message...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (tee *teeLogger) ProcessStatus(service string, status *client.SolveStatus) error {
+	for _, logger := range tee.loggers {
+		if err := logger.ProcessStatus(service, status); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (tee *teeLogger) Close() {
+	for _, logger := range tee.loggers {
+		logger.Close()
+	}
+}
+
+func (tee *teeLogger) AddLogLineListener(processLog func(service, logLine string)) {
+	for _, logger := range tee.loggers {
+		logger.AddLogLineListener(processLog)
+	}
+}
@@ -0,0 +1,287 @@
+package build
+
+import (
+	"fmt"
+	"github.com/moby/buildkit/client"
+	"golang.org/x/crypto/ssh/terminal"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+//terminalVertexState is the last known state of a single buildkit vertex,
+//keyed by its digest so repeated status ticks update it in place
+type terminalVertexState struct {
+	name      string
+	cached    bool
+	completed bool
+	error     string
+	current   int64
+	total     int64
+	//logTail holds this vertex's last maxLogTailLines lines of raw build
+	//output, so an errored vertex can show useful context in the live
+	//region; non-error output otherwise only reaches the flatfile tee, not
+	//the terminal
+	logTail []string
+}
+
+//maxLogTailLines caps how many of a vertex's most recent log lines are kept
+//for display if it errors
+const maxLogTailLines = 5
+
+func (v *terminalVertexState) appendLogLine(line string) {
+	v.logTail = append(v.logTail, line)
+	if len(v.logTail) > maxLogTailLines {
+		v.logTail = v.logTail[len(v.logTail)-maxLogTailLines:]
+	}
+}
+
+type terminalServiceState struct {
+	order    []string //vertex digests, in first-seen order, so the render is stable
+	vertices map[string]*terminalVertexState
+}
+
+//maxVertexLinesPerService caps how many of a service's vertices are shown
+//at once, so one service with many layers doesn't crowd out the others
+const maxVertexLinesPerService = 6
+
+//maxRenderedLinesFallback bounds the live region when the terminal's height
+//can't be determined (e.g. a pseudo-tty without ioctl support)
+const maxRenderedLinesFallback = 40
+
+type terminalLogger struct {
+	mutex            sync.Mutex
+	serviceOrder     []string
+	services         map[string]*terminalServiceState
+	isTTY            bool
+	linesDrawn       int
+	redraw           chan struct{}
+	done             chan struct{}
+	logLineListeners []func(service, logLine string)
+}
+
+//NewTerminalLogger builds a Logger which renders the live state of every
+//vertex of every service in a bounded region at the bottom of the terminal,
+//redrawing it in place as builds progress instead of flatfileLogger's
+//seek-and-overwrite trick (which only works against a regular file). When
+//stdout is not a TTY, it falls back to plain, append-only line output.
+//Compose it with a flatfileLogger via Tee so file logs are still written.
+func NewTerminalLogger() Logger {
+	logger := &terminalLogger{
+		services:         make(map[string]*terminalServiceState),
+		isTTY:            terminal.IsTerminal(int(os.Stdout.Fd())),
+		redraw:           make(chan struct{}, 1),
+		done:             make(chan struct{}),
+		logLineListeners: []func(service, logLine string){},
+	}
+	if logger.isTTY {
+		go logger.renderLoop()
+	}
+	return logger
+}
+
+func (logger *terminalLogger) serviceState(service string) *terminalServiceState {
+	state, ok := logger.services[service]
+	if !ok {
+		state = &terminalServiceState{vertices: make(map[string]*terminalVertexState)}
+		logger.services[service] = state
+		logger.serviceOrder = append(logger.serviceOrder, service)
+	}
+	return state
+}
+
+//requestRedraw wakes the rendering goroutine without blocking the caller;
+//if a redraw is already pending it is dropped, since the next one will pick
+//up every update made in the meantime
+func (logger *terminalLogger) requestRedraw() {
+	select {
+	case logger.redraw <- struct{}{}:
+	default:
+	}
+}
+
+func (logger *terminalLogger) renderLoop() {
+	for {
+		select {
+		case <-logger.redraw:
+			logger.render()
+		case <-logger.done:
+			return
+		}
+	}
+}
+
+func (logger *terminalLogger) render() {
+	logger.mutex.Lock()
+	lines := logger.renderLines()
+	logger.mutex.Unlock()
+
+	if logger.linesDrawn > 0 {
+		fmt.Printf("\033[%dA\033[J", logger.linesDrawn) //move up over, then clear, the previous render
+	}
+	if len(lines) > 0 {
+		fmt.Print(strings.Join(lines, "\n") + "\n")
+	}
+	logger.linesDrawn = len(lines)
+}
+
+//renderLines builds the live region, bounded so the \033[NA cursor-up in
+//render never overshoots: each service shows at most its last
+//maxVertexLinesPerService vertices, and the whole region is then capped to
+//the terminal height (or maxRenderedLinesFallback if that can't be read)
+func (logger *terminalLogger) renderLines() []string {
+	var lines []string
+	for _, service := range logger.serviceOrder {
+		state := logger.services[service]
+		lines = append(lines, service+":")
+
+		digests := state.order
+		if hidden := len(digests) - maxVertexLinesPerService; hidden > 0 {
+			lines = append(lines, fmt.Sprintf("  ... %d more", hidden))
+			digests = digests[hidden:]
+		}
+		for _, digest := range digests {
+			vertex := state.vertices[digest]
+			lines = append(lines, "  "+terminalVertexLine(vertex))
+			if vertex.error != "" {
+				for _, logLine := range vertex.logTail {
+					lines = append(lines, "    "+logLine)
+				}
+			}
+		}
+	}
+	return boundRenderedLines(lines)
+}
+
+//boundRenderedLines truncates lines to fit the terminal's height, keeping
+//the most recent ones
+func boundRenderedLines(lines []string) []string {
+	maxLines := maxRenderedLinesFallback
+	if _, height, err := terminal.GetSize(int(os.Stdout.Fd())); err == nil && height > 1 {
+		maxLines = height - 1 //leave a line for the shell prompt
+	}
+	if len(lines) <= maxLines {
+		return lines
+	}
+	hidden := len(lines) - maxLines + 1
+	return append([]string{fmt.Sprintf("... %d earlier lines hidden ...", hidden)}, lines[len(lines)-maxLines+1:]...)
+}
+
+func terminalVertexLine(v *terminalVertexState) string {
+	switch {
+	case v.error != "":
+		return fmt.Sprintf("[error] %s: %s", v.name, v.error)
+	case v.cached:
+		return fmt.Sprintf("[cached] %s", v.name)
+	case v.completed:
+		return fmt.Sprintf("[done] %s", v.name)
+	case v.total != 0:
+		return fmt.Sprintf("[running] %s (%s/%s)", v.name, humanReadableBytes(v.current), humanReadableBytes(v.total))
+	default:
+		return fmt.Sprintf("[running] %s", v.name)
+	}
+}
+
+//Log is a no-op on the live region itself: on a TTY, raw log lines only
+//reach the terminal if their vertex errors (via logTail in ProcessStatus);
+//otherwise they're forwarded to logLineListeners only, so e.g. a
+//flatfileLogger Tee'd alongside this one still has the full output on disk
+func (logger *terminalLogger) Log(service string, when time.Time, message ...interface{}) error {
+	messageString := strings.Trim(fmt.Sprint(message...), "\r\n")
+	if !logger.isTTY {
+		fmt.Printf("%s [%s] %s\n", service, when.In(time.Local), messageString)
+	}
+	for _, listener := range logger.logLineListeners {
+		listener(service, messageString+"\n")
+	}
+	return nil
+}
+
+func (logger *terminalLogger) ProcessStatus(service string, status *client.SolveStatus) error {
+	if !logger.isTTY {
+		return logger.processStatusPlain(service, status)
+	}
+
+	logger.mutex.Lock()
+	state := logger.serviceState(service)
+	for _, v := range status.Vertexes {
+		digest := v.Digest.String()
+		vertex, ok := state.vertices[digest]
+		if !ok {
+			vertex = &terminalVertexState{}
+			state.vertices[digest] = vertex
+			state.order = append(state.order, digest)
+		}
+		vertex.name = v.Name
+		vertex.cached = v.Cached
+		vertex.error = v.Error
+		vertex.completed = v.Completed != nil
+	}
+	for _, vs := range status.Statuses {
+		digest := vs.Vertex.String()
+		vertex, ok := state.vertices[digest]
+		if !ok {
+			vertex = &terminalVertexState{name: vs.ID}
+			state.vertices[digest] = vertex
+			state.order = append(state.order, digest)
+		}
+		vertex.current = vs.Current
+		vertex.total = vs.Total
+		vertex.completed = vertex.completed || vs.Completed != nil
+	}
+	for _, log := range status.Logs {
+		digest := log.Vertex.String()
+		vertex, ok := state.vertices[digest]
+		if !ok {
+			vertex = &terminalVertexState{}
+			state.vertices[digest] = vertex
+			state.order = append(state.order, digest)
+		}
+		vertex.appendLogLine(strings.Trim(string(log.Data), "\r\n"))
+	}
+	logger.mutex.Unlock()
+
+	logger.requestRedraw()
+	return nil
+}
+
+//processStatusPlain is used when stdout is not a TTY, where overwriting
+//previously drawn lines isn't possible
+func (logger *terminalLogger) processStatusPlain(service string, status *client.SolveStatus) error {
+	for _, v := range status.Vertexes {
+		if strings.Index(v.Name, "[internal]") != 0 {
+			logMessage := v.Name
+			if v.Cached {
+				logMessage = "cached: " + logMessage
+			}
+			if v.Error != "" {
+				fmt.Printf("%s: %s: LAYERID=%s\n", service, v.Error, v.Digest.String())
+			}
+			fmt.Printf("%s: %s\n", service, logMessage)
+		}
+	}
+	for _, vs := range status.Statuses {
+		var statusText string
+		if vs.Total != 0 {
+			statusText = fmt.Sprintf("%s %s/%s", vs.ID, humanReadableBytes(vs.Current), humanReadableBytes(vs.Total))
+		} else {
+			statusText = fmt.Sprintf("%s %s", vs.ID, humanReadableBytes(vs.Current))
+		}
+		fmt.Printf("%s: %s\n", service, statusText)
+	}
+	for _, log := range status.Logs {
+		fmt.Printf("%s: %s\n", service, strings.Trim(string(log.Data), "\r\n"))
+	}
+	return nil
+}
+
+func (logger *terminalLogger) Close() {
+	if logger.isTTY {
+		close(logger.done)
+	}
+}
+
+func (logger *terminalLogger) AddLogLineListener(processLog func(service, logLine string)) {
+	logger.logLineListeners = append(logger.logLineListeners, processLog)
+}
@@ -9,24 +9,24 @@ import (
 	"os"
 	"os/exec"
 	"os/user"
-	"sigs.k8s.io/kind/pkg/cluster"
-	kindconfig "sigs.k8s.io/kind/pkg/cluster/config"
-	"sigs.k8s.io/kind/pkg/cluster/config/encoding"
-	"sigs.k8s.io/kind/pkg/cluster/create"
-	kindnode "sigs.k8s.io/kind/pkg/cluster/nodes"
-	"sigs.k8s.io/kind/pkg/container/cri"
 	"strings"
 	"time"
 )
 
-var kindContext = cluster.NewContext("sanic")
-
-func clusterNodes() ([]kindnode.Node, error) {
-	return kindnode.List("label=io.k8s.sigs.kind.cluster=sanic")
+func (provisioner *ProvisionerLocalDev) nodes() ([]EngineNode, error) {
+	engine, err := provisioner.engine()
+	if err != nil {
+		return nil, err
+	}
+	return engine.ListNodes()
 }
 
-func clusterMasterNodes() ([]kindnode.Node, error) {
-	return kindnode.List("label=io.k8s.sigs.kind.cluster=sanic", "label=io.k8s.sigs.kind.role=control-plane")
+func (provisioner *ProvisionerLocalDev) masterNodes() ([]EngineNode, error) {
+	engine, err := provisioner.engine()
+	if err != nil {
+		return nil, err
+	}
+	return engine.ListNodes("role=control-plane")
 }
 
 func (provisioner *ProvisionerLocalDev) checkClusterReady() error {
@@ -103,146 +103,349 @@ func (provisioner *ProvisionerLocalDev) checkClusterReady() error {
 	return fmt.Errorf("cluster is not ready, and has not been for over a minute")
 }
 
+//nodeReadiness returns, for every k8s node currently registered with the
+//cluster, whether its Ready condition is True. A node that hasn't
+//(re)joined yet - e.g. one that was just restarted and whose kubelet hasn't
+//registered back - is simply absent from the map, rather than reported as
+//either ready or not ready.
+func (provisioner *ProvisionerLocalDev) nodeReadiness() (map[string]bool, error) {
+	cmd := exec.Command(
+		"kubectl",
+		"--kubeconfig="+provisioner.KubeConfigLocation(),
+		"get",
+		"nodes",
+		"-o",
+		`jsonpath={range .items[*]}{.metadata.name}{" "}{.status.conditions[?(@.type=="Ready")].status}{"\n"}{end}`,
+	)
+	stdout := &bytes.Buffer{}
+	stderr := &bytes.Buffer{}
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("could not check node readiness: %s %s", err.Error(), stderr.String())
+	}
+
+	readiness := map[string]bool{}
+	for _, line := range strings.Split(strings.TrimSpace(stdout.String()), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			continue
+		}
+		readiness[fields[0]] = fields[1] == "True"
+	}
+	return readiness, nil
+}
+
+//notReadyNodeNames returns the names of the k8s nodes that are registered
+//but whose Ready condition isn't True, so reconcileCluster can restart a
+//worker whose container is up but whose kubelet has wedged, not just
+//workers whose container disappeared outright
+func (provisioner *ProvisionerLocalDev) notReadyNodeNames() ([]string, error) {
+	readiness, err := provisioner.nodeReadiness()
+	if err != nil {
+		return nil, err
+	}
+	var notReady []string
+	for name, ready := range readiness {
+		if !ready {
+			notReady = append(notReady, name)
+		}
+	}
+	return notReady, nil
+}
+
+//ReconcilePolicy controls how aggressively checkCluster tries to repair a
+//degraded cluster before giving up and recreating it from scratch
+type ReconcilePolicy struct {
+	//Timeout bounds how long we wait for restarted nodes to rejoin
+	Timeout time.Duration
+	//MaxRestartsPerNode caps how many times reconciliation will retry
+	//restarting the missing nodes before falling back to a full recreate
+	MaxRestartsPerNode int
+	//Prompt, if true, asks the user for confirmation before restarting nodes
+	Prompt bool
+}
+
+//DefaultReconcilePolicy is used by checkCluster, and is conservative enough
+//to run unattended from `sanic start`
+var DefaultReconcilePolicy = ReconcilePolicy{
+	Timeout:            2 * time.Minute,
+	MaxRestartsPerNode: 1,
+	Prompt:             false,
+}
+
 func (provisioner *ProvisionerLocalDev) checkCluster() error {
-	nodes, err := clusterNodes()
+	return provisioner.reconcileCluster(DefaultReconcilePolicy)
+}
+
+//reconcileCluster checks the state of the sanic-* nodes and, if the control
+//plane is healthy but 1-2 workers are missing or not ready, tries to repair
+//the cluster in place: restart the stopped/not-ready nodes, wait for them to
+//rejoin, then re-run patchRegistryContainers against just the recovered
+//nodes (the registry config patch is idempotent thanks to its `grep -q`
+//guard, but there's no reason to touch nodes that were never restarted).
+//Only when the control plane itself is gone, too many workers are missing,
+//the engine can't restart individual nodes, or reconciliation times out
+//does it fall back to deleteClusterContainers+startCluster.
+func (provisioner *ProvisionerLocalDev) reconcileCluster(policy ReconcilePolicy) error {
+	nodes, err := provisioner.nodes()
 	if err != nil {
 		return err
 	}
 
-	requiredContainersRunning := map[string]*kindnode.Node{
-		"sanic-worker":        nil,
-		"sanic-worker2":       nil,
-		"sanic-worker3":       nil,
-		"sanic-control-plane": nil,
+	requiredNodesRunning := map[string]bool{
+		"sanic-worker":        false,
+		"sanic-worker2":       false,
+		"sanic-worker3":       false,
+		"sanic-control-plane": false,
 	}
 
 	for _, node := range nodes {
-		if _, ok := requiredContainersRunning[node.Name()]; ok {
-			requiredContainersRunning[node.Name()] = &node
+		if _, ok := requiredNodesRunning[node.Name]; ok {
+			requiredNodesRunning[node.Name] = true
 		}
 	}
 
 	if len(nodes) == 0 {
-		return fmt.Errorf("no nodes were running, cluster has to be provisioned once per docker engine restart")
+		return fmt.Errorf("no nodes were running, cluster has to be provisioned once per engine restart")
 	}
 
-	if len(nodes) != len(requiredContainersRunning) {
-		return fmt.Errorf("some nodes have been removed/crashed. only %d/%d were running",
-			len(nodes), len(requiredContainersRunning))
+	if !requiredNodesRunning["sanic-control-plane"] {
+		return provisioner.recreateCluster("the control plane is gone, reconciliation is not possible")
 	}
-	for _, node := range requiredContainersRunning {
-		if node == nil {
-			return fmt.Errorf("some nodes were not running while others were, try deleting your cluster containers with docker rm")
+
+	var missing []string
+	for name, running := range requiredNodesRunning {
+		if !running && name != "sanic-control-plane" {
+			missing = append(missing, name)
+		}
+	}
+
+	if len(missing) == 0 {
+		notReady, err := provisioner.notReadyNodeNames()
+		if err != nil {
+			return err
+		}
+		for _, name := range notReady {
+			if _, ok := requiredNodesRunning[name]; ok && name != "sanic-control-plane" {
+				missing = append(missing, name)
+			}
 		}
 	}
 
+	if len(missing) == 0 {
+		return provisioner.checkClusterReady()
+	}
+
+	if len(missing) > 2 {
+		return provisioner.recreateCluster(fmt.Sprintf(
+			"%d/%d workers are missing, too many to reconcile", len(missing), len(requiredNodesRunning)-1))
+	}
+
+	engine, err := provisioner.engine()
+	if err != nil {
+		return err
+	}
+	restarter, ok := engine.(restartableEngine)
+	if !ok {
+		return provisioner.recreateCluster("the current engine does not support restarting individual nodes")
+	}
+
+	if policy.Prompt && !promptYesNo(fmt.Sprintf(
+		"%d worker node(s) are missing (%s). restart them?", len(missing), strings.Join(missing, ", "))) {
+		return fmt.Errorf("%d worker node(s) are missing, and you chose not to restart them", len(missing))
+	}
+
+	if policy.MaxRestartsPerNode < 1 {
+		return provisioner.recreateCluster("the reconcile policy allows no node restarts")
+	}
+
+	for attempt := 0; attempt < policy.MaxRestartsPerNode; attempt++ {
+		for _, name := range missing {
+			if err := restarter.StartNode(name); err != nil {
+				return fmt.Errorf("could not restart node %s: %s", name, err.Error())
+			}
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), policy.Timeout)
+		waitErr := provisioner.waitForNodesReady(ctx, missing)
+		cancel()
+		if waitErr == nil {
+			break
+		}
+		if attempt == policy.MaxRestartsPerNode-1 {
+			return provisioner.recreateCluster(fmt.Sprintf(
+				"restarted nodes did not rejoin within %s: %s", policy.Timeout, waitErr.Error()))
+		}
+	}
+
+	if err := provisioner.patchRegistryContainers(context.Background(), missing...); err != nil {
+		return fmt.Errorf("recovered nodes rejoined, but could not re-patch their registry trust: %s", err.Error())
+	}
+
 	return provisioner.checkClusterReady()
 }
 
-func deleteClusterContainers() error {
-	nodes, err := clusterNodes()
+//recreateCluster is the fallback path when reconcileCluster can't repair the
+//cluster in place
+func (provisioner *ProvisionerLocalDev) recreateCluster(reason string) error {
+	fmt.Printf("reconciliation is falling back to recreating the cluster: %s\n", reason)
+	if err := provisioner.deleteClusterContainers(); err != nil {
+		return fmt.Errorf("could not delete existing containers to recreate the cluster: %s", err.Error())
+	}
+	return provisioner.startCluster()
+}
+
+//waitForNodesReady polls until every named node is both registered with the
+//cluster and Ready, or ctx is done. Polling container presence alone isn't
+//enough: a freshly restarted node's container reappears in ListNodes almost
+//immediately, long before its kubelet has rejoined and the node is actually
+//usable.
+func (provisioner *ProvisionerLocalDev) waitForNodesReady(ctx context.Context, names []string) error {
+	for {
+		readiness, err := provisioner.nodeReadiness()
+		if err != nil {
+			return err
+		}
+		allReady := true
+		for _, name := range names {
+			if !readiness[name] {
+				allReady = false
+			}
+		}
+		if allReady {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+func promptYesNo(question string) bool {
+	for {
+		fmt.Printf("%s [Y/n]: ", question)
+		var resp string
+		fmt.Scanln(&resp)
+		switch resp {
+		case "y", "Y", "":
+			return true
+		case "n", "N":
+			return false
+		default:
+			fmt.Printf("Did not understand response: %s, expected y/n\n", resp)
+		}
+	}
+}
+
+func (provisioner *ProvisionerLocalDev) deleteClusterContainers() error {
+	engine, err := provisioner.engine()
+	if err != nil {
+		return err
+	}
+	nodes, err := engine.ListNodes()
 	if err != nil {
 		return err
 	}
 	eg := errgroup.Group{}
 	for _, node := range nodes {
-		name := node.Name()
+		name := node.Name
 		eg.Go(func() error {
-			cmd := exec.Command("docker", "rm", "-f", name)
-			return cmd.Run()
+			return engine.RemoveNode(name)
 		})
 	}
 	return eg.Wait()
 }
 
-const nodeRegistryConfigPatch = `
-grep -q '[REGISTRY]' /etc/containerd/config.toml || \
-{ sed -i -e '/\[plugins\.cri\.registry\.mirrors\]/a\' \
-  -e '        [plugins.cri.registry.mirrors."[REGISTRY]"]\' \
-  -e '          endpoint = ["http://[REGISTRY]"]' \
-  /etc/containerd/config.toml;
-  systemctl restart containerd;
-}
-`
+//patchRegistryContainers makes the internal docker registry trusted by the
+//nodes, to allow local pushes there. The config patch checks if the
+//registry has already been patched; if it hasn't, it rewrites the node's
+//runtime config to allow insecure pulls via HTTP from it, and restarts that
+//runtime for the configuration change to take effect. The patch script
+//itself depends on whether the engine's nodes run containerd (dockerKindEngine)
+//or CRI-O (podmanCRIOEngine). If onlyNodes is non-empty, just those nodes are
+//patched (reconcileCluster uses this to leave nodes that were already
+//healthy untouched); an empty onlyNodes patches
+//every node in the cluster.
+func (provisioner *ProvisionerLocalDev) patchRegistryContainers(ctx context.Context, onlyNodes ...string) error {
+	engine, err := provisioner.engine()
+	if err != nil {
+		return err
+	}
 
-//patchRegistryContainers makes the internal docker registry trusted by the nodes, to allow local pushes there
-//the config patch checks if the registry has already been patched,
-//  if it hasn't been patched, it inserts two new lines in /etc/containerd/config.toml to allow insecure pulls via HTTP
-//  from it, and then restarts containerd for the configuration change to take effect
-func (provisioner *ProvisionerLocalDev) patchRegistryContainers(ctx context.Context) error {
-	nodes, err := clusterNodes()
+	nodes, err := engine.ListNodes()
 	if err != nil {
 		return err
 	}
 
-	registry, err := provisioner.Registry()
+	if len(onlyNodes) > 0 {
+		wanted := make(map[string]bool, len(onlyNodes))
+		for _, name := range onlyNodes {
+			wanted[name] = true
+		}
+		filtered := nodes[:0]
+		for _, node := range nodes {
+			if wanted[node.Name] {
+				filtered = append(filtered, node)
+			}
+		}
+		nodes = filtered
+	}
+
+	registry, err := engine.Registry()
 	if err != nil {
 		return err
 	}
 
+	patch := nodeRegistryConfigPatch
+	if _, ok := engine.(*podmanCRIOEngine); ok {
+		patch = nodeRegistryConfigPatchCRIO
+	}
+	patchScript := strings.ReplaceAll(patch, `[REGISTRY]`, registry)
+
 	var funcs []func(context.Context) error
 	for _, node := range nodes {
-		containerIdentifier := node.Name()
+		name := node.Name
 		funcs = append(funcs, func(ctx context.Context) error {
-			cmd := exec.Command(
-				"docker", "exec", containerIdentifier,
-				"bash", "-c",
-				strings.ReplaceAll(nodeRegistryConfigPatch, `[REGISTRY]`, registry),
-			)
-			cmd.Start()
-			return util.WaitCmdContextually(ctx, cmd)
+			return engine.ExecInNode(ctx, name, "bash", "-c", patchScript)
 		})
 	}
 	return util.RunContextuallyInParallel(ctx, funcs...)
 }
 
-func (provisioner *ProvisionerLocalDev) startCluster() error {
+func defaultExtraMounts() ([]EngineMount, error) {
 	usr, err := user.Current()
 	if err != nil {
-		return fmt.Errorf("could not find your home directory: %s", err.Error())
+		return nil, fmt.Errorf("could not find your home directory: %s", err.Error())
 	}
 
-	cfg := kindconfig.Cluster{}
-	encoding.Scheme.Default(&cfg)
-	nodeMounts := []cri.Mount{
-		{
-			ContainerPath: "/hosthome",
-			HostPath:      usr.HomeDir,
-			Readonly:      true,
-		},
+	mounts := []EngineMount{
+		{ContainerPath: "/hosthome", HostPath: usr.HomeDir, Readonly: true},
 	}
 	if _, err := os.Stat("/mnt"); err == nil {
-		nodeMounts = append(nodeMounts, cri.Mount{
-			ContainerPath: "/mnt",
-			HostPath: "/mnt",
-			Readonly: true,
-		})
+		mounts = append(mounts, EngineMount{ContainerPath: "/mnt", HostPath: "/mnt", Readonly: true})
+	}
+	return mounts, nil
+}
+
+func (provisioner *ProvisionerLocalDev) startCluster() error {
+	extraMounts, err := defaultExtraMounts()
+	if err != nil {
+		return err
+	}
+
+	engine, err := provisioner.engine()
+	if err != nil {
+		return err
 	}
 
-	cfg.Nodes = []kindconfig.Node{
-		{
-			Role:        kindconfig.ControlPlaneRole,
-			ExtraMounts: nodeMounts,
-		},
-		{
-			Role:        kindconfig.WorkerRole,
-			ExtraMounts: nodeMounts,
-		},
-		{
-			Role:        kindconfig.WorkerRole,
-			ExtraMounts: nodeMounts,
-		},
-		{
-			Role:        kindconfig.WorkerRole,
-			ExtraMounts: nodeMounts,
-		},
-	}
-
-	//TODO HACK: kind does not always work if the containers are not manually removed first
-	if err := deleteClusterContainers(); err != nil {
+	//TODO HACK: the node containers don't always come up cleanly if stale ones aren't removed first
+	if err := provisioner.deleteClusterContainers(); err != nil {
 		//noinspection ALL
-		return fmt.Errorf("could not delete existing containers to run cluster setup: %s. Is the docker engine running?", err.Error())
+		return fmt.Errorf("could not delete existing containers to run cluster setup: %s. Is the engine running?", err.Error())
 	}
 
-	return kindContext.Create(&cfg, create.Retain(false))
+	return engine.CreateCluster(EngineClusterConfig{ExtraMounts: extraMounts})
 }
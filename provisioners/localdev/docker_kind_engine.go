@@ -0,0 +1,108 @@
+package localdev
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"sigs.k8s.io/kind/pkg/cluster"
+	kindconfig "sigs.k8s.io/kind/pkg/cluster/config"
+	"sigs.k8s.io/kind/pkg/cluster/config/encoding"
+	"sigs.k8s.io/kind/pkg/cluster/create"
+	kindnode "sigs.k8s.io/kind/pkg/cluster/nodes"
+	"sigs.k8s.io/kind/pkg/container/cri"
+	"strings"
+)
+
+var kindContext = cluster.NewContext("sanic")
+
+//dockerKindEngine is the original, and still default, Engine: a kind
+//cluster running on top of the docker engine
+type dockerKindEngine struct {
+	provisioner *ProvisionerLocalDev
+}
+
+func (engine *dockerKindEngine) CreateCluster(cfg EngineClusterConfig) error {
+	kindCfg := kindconfig.Cluster{}
+	encoding.Scheme.Default(&kindCfg)
+
+	nodeMounts := make([]cri.Mount, len(cfg.ExtraMounts))
+	for i, m := range cfg.ExtraMounts {
+		nodeMounts[i] = cri.Mount{
+			ContainerPath: m.ContainerPath,
+			HostPath:      m.HostPath,
+			Readonly:      m.Readonly,
+		}
+	}
+
+	kindCfg.Nodes = []kindconfig.Node{
+		{Role: kindconfig.ControlPlaneRole, ExtraMounts: nodeMounts},
+		{Role: kindconfig.WorkerRole, ExtraMounts: nodeMounts},
+		{Role: kindconfig.WorkerRole, ExtraMounts: nodeMounts},
+		{Role: kindconfig.WorkerRole, ExtraMounts: nodeMounts},
+	}
+
+	return kindContext.Create(&kindCfg, create.Retain(false))
+}
+
+func (engine *dockerKindEngine) ListNodes(labels ...string) ([]EngineNode, error) {
+	kindLabels := []string{"label=io.k8s.sigs.kind.cluster=sanic"}
+	for _, label := range labels {
+		if role := strings.TrimPrefix(label, "role="); role != label {
+			kindLabels = append(kindLabels, "label=io.k8s.sigs.kind.role="+role)
+		}
+	}
+
+	nodes, err := kindnode.List(kindLabels...)
+	if err != nil {
+		return nil, err
+	}
+	engineNodes := make([]EngineNode, len(nodes))
+	for i, node := range nodes {
+		role := "worker"
+		if strings.Contains(node.Name(), "control-plane") {
+			role = "control-plane"
+		}
+		engineNodes[i] = EngineNode{Name: node.Name(), Role: role}
+	}
+	return engineNodes, nil
+}
+
+func (engine *dockerKindEngine) ExecInNode(ctx context.Context, node string, argv ...string) error {
+	cmd := exec.CommandContext(ctx, "docker", append([]string{"exec", node}, argv...)...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if stderr.Len() > 0 {
+			return fmt.Errorf("%s: %s", err.Error(), strings.TrimSpace(stderr.String()))
+		}
+		return err
+	}
+	return nil
+}
+
+func (engine *dockerKindEngine) RemoveNode(name string) error {
+	return exec.Command("docker", "rm", "-f", name).Run()
+}
+
+//StartNode uses `docker restart` rather than `docker start`: a stopped node
+//needs starting, but a running-yet-NotReady one (a wedged kubelet) needs an
+//actual restart to have any chance of recovering - `docker start` on an
+//already-running container is a no-op
+func (engine *dockerKindEngine) StartNode(name string) error {
+	return exec.Command("docker", "restart", name).Run()
+}
+
+func (engine *dockerKindEngine) Registry() (string, error) {
+	return engine.provisioner.Registry()
+}
+
+const nodeRegistryConfigPatch = `
+grep -q '[REGISTRY]' /etc/containerd/config.toml || \
+{ sed -i -e '/\[plugins\.cri\.registry\.mirrors\]/a\' \
+  -e '        [plugins.cri.registry.mirrors."[REGISTRY]"]\' \
+  -e '          endpoint = ["http://[REGISTRY]"]' \
+  /etc/containerd/config.toml;
+  systemctl restart containerd;
+}
+`
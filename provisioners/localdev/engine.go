@@ -0,0 +1,75 @@
+package localdev
+
+import (
+	"context"
+	"fmt"
+	"os"
+)
+
+//EngineNode is a single node of a local cluster, however the underlying
+//Engine created it
+type EngineNode struct {
+	Name string
+	Role string //"control-plane" or "worker"
+}
+
+//EngineMount is a bind mount that should be present inside every node
+type EngineMount struct {
+	ContainerPath string
+	HostPath      string
+	Readonly      bool
+}
+
+//EngineClusterConfig describes the cluster that Engine.CreateCluster should
+//provision: 1 control-plane node plus 3 worker nodes, each with the given
+//extra mounts
+type EngineClusterConfig struct {
+	ExtraMounts []EngineMount
+}
+
+//Engine abstracts over the container runtime and cluster tooling used to
+//bring up and manage a local multi-node cluster, so localdev isn't
+//hardwired to Docker+kind. dockerKindEngine wraps the original
+//implementation; podmanCRIOEngine brings up an equivalent topology using
+//rootless podman and CRI-O.
+type Engine interface {
+	//CreateCluster brings up a fresh 1 control-plane + 3 worker cluster
+	CreateCluster(cfg EngineClusterConfig) error
+	//ListNodes returns the cluster's nodes, filtered by any "role=<role>"
+	//label given, e.g. ListNodes("role=control-plane"). Labels with any
+	//other key are ignored.
+	ListNodes(labels ...string) ([]EngineNode, error)
+	//ExecInNode runs argv inside node, returning an error including stderr
+	//if it exited non-zero
+	ExecInNode(ctx context.Context, node string, argv ...string) error
+	//RemoveNode force-removes a single node's container
+	RemoveNode(name string) error
+	//Registry returns the host:port of the in-cluster image registry
+	Registry() (string, error)
+}
+
+//engine resolves which Engine implementation this provisioner should use,
+//driven by the `engine: docker|podman` key of the sanic environment config
+//(defaulting to docker, the long-standing behavior)
+func (provisioner *ProvisionerLocalDev) engine() (Engine, error) {
+	switch name := provisioner.EngineName(); name {
+	case "", "docker":
+		return &dockerKindEngine{provisioner: provisioner}, nil
+	case "podman":
+		//podmanCRIOEngine is experimental: unlike dockerKindEngine, it has no
+		//Go client library driving cluster bring-up, so it depends on
+		//podmanCRIONodeImage's own entrypoint to run kubeadm init/join - see
+		//podmanCRIONodeImage's doc comment
+		fmt.Fprintln(os.Stderr, "warning: engine \"podman\" is experimental")
+		return &podmanCRIOEngine{provisioner: provisioner}, nil
+	default:
+		return nil, fmt.Errorf("unknown engine %q in sanic config, expected \"docker\" or \"podman\"", name)
+	}
+}
+
+//restartableEngine is an optional capability: engines that back nodes with
+//long-lived, stoppable containers (as opposed to recreating them outright)
+//can implement it so reconcileCluster can restart individual nodes in place
+type restartableEngine interface {
+	StartNode(name string) error
+}
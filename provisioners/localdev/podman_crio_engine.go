@@ -0,0 +1,148 @@
+package localdev
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+//podmanCRIONodeImage is the node image used in place of kind's
+//kindest/node: a CRI-O + kubeadm image that boots the same way under
+//rootless podman. EXPERIMENTAL: unlike kindest/node, which kind's Go client
+//library drives through an explicit kubeadm init/join dance after the
+//container starts, podmanCRIOEngine has no such client and never calls
+//kubeadm itself - it assumes this image's own entrypoint runs `kubeadm init`
+//on sanic-control-plane and `kubeadm join` on the workers (e.g. via a
+//systemd unit baked into the image, the way kindest/node bootstraps).
+//CreateCluster only guarantees the containers exist in the right order; it
+//does not guarantee a cluster has actually formed.
+const podmanCRIONodeImage = "quay.io/sanic/podman-crio-node:latest"
+
+//podmanCRIONodeOrder is the fixed creation order for CreateCluster: the
+//control plane has to exist before any worker can kubeadm-join it
+var podmanCRIONodeOrder = []string{
+	"sanic-control-plane",
+	"sanic-worker",
+	"sanic-worker2",
+	"sanic-worker3",
+}
+
+var podmanCRIORoles = map[string]string{
+	"sanic-control-plane": "control-plane",
+	"sanic-worker":        "worker",
+	"sanic-worker2":       "worker",
+	"sanic-worker3":       "worker",
+}
+
+//podmanCRIOEngine brings up an equivalent topology to dockerKindEngine, but
+//using rootless podman to run the node containers and CRI-O (instead of
+//containerd) as the in-node runtime. It shells out to `podman` directly,
+//since unlike kind there is no Go client library for this combination.
+type podmanCRIOEngine struct {
+	provisioner *ProvisionerLocalDev
+}
+
+//CreateCluster creates the control plane, then the workers, in that fixed
+//order (podmanCRIONodeOrder), since kubeadm join requires the control plane
+//to already be up. If a node fails to create, the nodes created so far are
+//torn down rather than left as orphaned containers, mirroring kind's
+//create.Retain(false).
+func (engine *podmanCRIOEngine) CreateCluster(cfg EngineClusterConfig) error {
+	for i, name := range podmanCRIONodeOrder {
+		role := podmanCRIORoles[name]
+		args := []string{
+			"run", "-d", "--name", name,
+			"--privileged", //CRI-O needs to manage cgroups/netns inside the node
+			"--label", "io.sanic.cluster=sanic",
+			"--label", "io.sanic.role=" + role,
+		}
+		for _, m := range cfg.ExtraMounts {
+			mount := fmt.Sprintf("type=bind,source=%s,destination=%s", m.HostPath, m.ContainerPath)
+			if m.Readonly {
+				mount += ",readonly"
+			}
+			args = append(args, "--mount", mount)
+		}
+		args = append(args, podmanCRIONodeImage)
+
+		if err := exec.Command("podman", args...).Run(); err != nil {
+			for _, created := range podmanCRIONodeOrder[:i] {
+				exec.Command("podman", "rm", "-f", created).Run()
+			}
+			return fmt.Errorf("could not create podman node %s: %s", name, err.Error())
+		}
+	}
+	return nil
+}
+
+func (engine *podmanCRIOEngine) ListNodes(labels ...string) ([]EngineNode, error) {
+	//-a is required: podman ps defaults to running containers only, but
+	//callers (e.g. deleteClusterContainers) need to find stopped nodes too
+	args := []string{"ps", "-a", "--filter", "label=io.sanic.cluster=sanic", "--format", "{{.Names}}"}
+	for _, label := range labels {
+		if role := strings.TrimPrefix(label, "role="); role != label {
+			args = append(args, "--filter", "label=io.sanic.role="+role)
+		}
+	}
+
+	out, err := exec.Command("podman", args...).Output()
+	if err != nil {
+		return nil, err
+	}
+
+	var nodes []EngineNode
+	for _, name := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		if name == "" {
+			continue
+		}
+		nodes = append(nodes, EngineNode{Name: name, Role: podmanCRIORoles[name]})
+	}
+	return nodes, nil
+}
+
+func (engine *podmanCRIOEngine) ExecInNode(ctx context.Context, node string, argv ...string) error {
+	cmd := exec.CommandContext(ctx, "podman", append([]string{"exec", node}, argv...)...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if stderr.Len() > 0 {
+			return fmt.Errorf("%s: %s", err.Error(), strings.TrimSpace(stderr.String()))
+		}
+		return err
+	}
+	return nil
+}
+
+func (engine *podmanCRIOEngine) RemoveNode(name string) error {
+	return exec.Command("podman", "rm", "-f", name).Run()
+}
+
+//StartNode implements restartableEngine, so reconcileCluster can repair a
+//degraded podman cluster in place instead of always falling back to a full
+//recreate. It uses `podman restart` rather than `podman start`, since a
+//stopped node needs starting but a running-yet-NotReady one (a wedged
+//kubelet/crio) needs an actual restart to have any chance of recovering -
+//`podman start` on an already-running container is a no-op.
+func (engine *podmanCRIOEngine) StartNode(name string) error {
+	return exec.Command("podman", "restart", name).Run()
+}
+
+func (engine *podmanCRIOEngine) Registry() (string, error) {
+	return engine.provisioner.Registry()
+}
+
+//nodeRegistryConfigPatchCRIO mirrors nodeRegistryConfigPatch, but writes a
+//drop-in under /etc/crio/crio.conf.d/ instead of editing containerd's
+//single config.toml, since that's how CRI-O expects insecure registries to
+//be configured
+const nodeRegistryConfigPatchCRIO = `
+grep -rq '[REGISTRY]' /etc/crio/crio.conf.d/ 2>/dev/null || \
+{ cat <<-SANICEOF > /etc/crio/crio.conf.d/99-sanic-registry.conf
+[crio.image]
+insecure_registries = ["[REGISTRY]"]
+SANICEOF
+  systemctl restart crio;
+}
+`
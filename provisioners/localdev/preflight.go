@@ -0,0 +1,107 @@
+package localdev
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+)
+
+//Preflight runs a suite of connectivity checks inside a control-plane node,
+//mirroring what minikube checks after start: that cluster DNS resolves
+//internal and external names, that the configured registry is reachable
+//over HTTPS, and that a push/pull round-trip against it succeeds. Failures
+//are aggregated into a single error with remediation hints instead of
+//aborting on the first one, so the user can fix everything in one pass.
+func (provisioner *ProvisionerLocalDev) Preflight(ctx context.Context) error {
+	engine, err := provisioner.engine()
+	if err != nil {
+		return err
+	}
+
+	masters, err := provisioner.masterNodes()
+	if err != nil {
+		return fmt.Errorf("could not find a control-plane node to run preflight checks from: %s", err.Error())
+	}
+	if len(masters) == 0 {
+		return fmt.Errorf("no control-plane node was found to run preflight checks from")
+	}
+	node := masters[0].Name
+
+	registry, err := engine.Registry()
+	if err != nil {
+		return err
+	}
+
+	var failures []string
+
+	if err := engine.ExecInNode(ctx, node, "nslookup", "kubernetes.default.svc.cluster.local"); err != nil {
+		failures = append(failures, fmt.Sprintf(
+			"internal DNS resolution failed (%s). Remediation: check that coredns is running with "+
+				"`kubectl -n kube-system get pods -l k8s-app=kube-dns`", err.Error()))
+	}
+
+	if err := engine.ExecInNode(ctx, node, "bash", "-c", "nslookup k8s.io 8.8.8.8 || nslookup k8s.io 1.1.1.1"); err != nil {
+		failures = append(failures, fmt.Sprintf(
+			"external DNS resolution failed (%s). Remediation: check that the cluster's network allows "+
+				"outbound DNS from the nodes", err.Error()))
+	}
+
+	curlArgs := []string{"curl", "-sS", "-o", "/dev/null"}
+	if proxy := os.Getenv("HTTPS_PROXY"); proxy != "" {
+		curlArgs = append(curlArgs, "-x", proxy)
+	}
+	curlArgs = append(curlArgs, "https://"+registry)
+	if err := engine.ExecInNode(ctx, node, curlArgs...); err != nil {
+		failures = append(failures, fmt.Sprintf(
+			"registry %s was not reachable over HTTPS (%s). Remediation: check that the registry is running, "+
+				"and that HTTPS_PROXY (if set) is allowed to reach it", registry, err.Error()))
+	}
+
+	if err := registryRoundTrip(ctx, engine, node, registry); err != nil {
+		failures = append(failures, fmt.Sprintf(
+			"push/pull round-trip against registry %s failed (%s). Remediation: re-run `sanic deploy`, which "+
+				"reconfigures registry trust on the nodes via patchRegistryContainers", registry, err.Error()))
+	}
+
+	if len(failures) == 0 {
+		return nil
+	}
+	return fmt.Errorf("cluster preflight checks failed:\n  - %s", strings.Join(failures, "\n  - "))
+}
+
+//registryRoundTrip pushes a tiny image to the in-cluster registry
+//patchRegistryContainers configured, then pulls it back, to make sure both
+//directions of the registry actually work rather than just its TLS endpoint
+func registryRoundTrip(ctx context.Context, engine Engine, node, registry string) error {
+	testImage := registry + "/sanic-preflight:latest"
+	push, pull := registryRoundTripCommands(engine, testImage)
+	if err := engine.ExecInNode(ctx, node, "bash", "-c", push); err != nil {
+		return err
+	}
+	return engine.ExecInNode(ctx, node, "bash", "-c", pull)
+}
+
+//registryRoundTripCommands returns the push and pull-back shell commands
+//registryRoundTrip runs inside the node, since the node's runtime CLI
+//depends on the engine: containerd's ctr on dockerKindEngine, podman itself
+//on podmanCRIOEngine (CRI-O has no bundled image CLI of its own)
+func registryRoundTripCommands(engine Engine, testImage string) (push, pull string) {
+	if _, ok := engine.(*podmanCRIOEngine); ok {
+		push = fmt.Sprintf(
+			"podman pull docker.io/library/busybox:latest && "+
+				"podman tag docker.io/library/busybox:latest %s && "+
+				"podman push --tls-verify=false %s",
+			testImage, testImage)
+		pull = fmt.Sprintf("podman pull --tls-verify=false %s", testImage)
+		return push, pull
+	}
+
+	push = fmt.Sprintf(
+		"ctr -n k8s.io images pull docker.io/library/busybox:latest && "+
+			"ctr -n k8s.io images tag docker.io/library/busybox:latest %s && "+
+			"ctr -n k8s.io images push --plain-http %s",
+		testImage, testImage)
+	pull = fmt.Sprintf("ctr -n k8s.io images pull --plain-http %s", testImage)
+	return push, pull
+}
@@ -0,0 +1,44 @@
+package localdev
+
+import "fmt"
+
+//ProvisionerLocalDev provisions a local, multi-node kubernetes cluster for
+//development, backed by whichever Engine the environment config selects
+type ProvisionerLocalDev struct {
+	//Name is the sanic environment this provisioner was configured for
+	Name string
+	//EngineConfigName is the `engine:` key from the environment config
+	//("docker" or "podman"), selecting which Engine implementation backs
+	//this cluster's nodes. Empty means the default, docker.
+	EngineConfigName string
+	//KubeConfigPath is where this cluster's kubeconfig is written
+	KubeConfigPath string
+	//RegistryAddress is the host:port of this environment's in-cluster
+	//image registry
+	RegistryAddress string
+}
+
+//KubeConfigLocation returns the path to the kubeconfig for this
+//provisioner's cluster
+func (provisioner *ProvisionerLocalDev) KubeConfigLocation() string {
+	return provisioner.KubeConfigPath
+}
+
+//Registry returns the host:port of this environment's in-cluster image
+//registry
+func (provisioner *ProvisionerLocalDev) Registry() (string, error) {
+	if provisioner.RegistryAddress == "" {
+		return "", fmt.Errorf("no registry is configured for environment %q", provisioner.Name)
+	}
+	return provisioner.RegistryAddress, nil
+}
+
+//EngineName returns the configured container engine for this provisioner,
+//defaulting to "docker" (the long-standing behavior) when the environment
+//config doesn't set one
+func (provisioner *ProvisionerLocalDev) EngineName() string {
+	if provisioner.EngineConfigName == "" {
+		return "docker"
+	}
+	return provisioner.EngineConfigName
+}